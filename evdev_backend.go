@@ -0,0 +1,1037 @@
+package main
+
+import (
+	"fmt"
+	"github.com/gvalkov/golang-evdev"
+	"math"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+const (
+	// Default values for the tunable Config.Device thresholds below,
+	// used whenever the config leaves them at zero.
+	DIST_SWIPE    = 1100 // The distance the finger must move to trigger a swipe (unknown unit)
+	DIST_OTHER    = 500  // The distance the finger must move to trigger other gestures (unknown unit)
+	CHECK_DELAY   = 50   // Delay between checking for gestures (ms)
+	RESET_TIMEOUT = 220  // How long an unrecognized movement is given before the fingers are reset (ms)
+
+	HOLD_DURATION = 300 * time.Millisecond // How long the fingers must rest still to trigger a hold
+	TAP_DURATION  = 200 * time.Millisecond // The longest a touch can last and still be considered a tap
+
+	ROTATE_THRESHOLD = 20.0 // The average angle (degrees) the fingers must rotate to trigger a rotate gesture
+)
+
+/*
+	Finger struct
+*/
+
+type Finger struct {
+	// The first x and y postion that is reported after the finger touches the pad
+	// or after the finger has been reset
+	FirstX int
+	FirstY int
+	// The last x and y postion that is reported while the fingers is touching the pad
+	LastX int
+	LastY int
+	// If the position has been set after the first touch or reset
+	HasPositionX bool
+	HasPositionY bool
+	// If this finger is currently touching the pas
+	IsActive bool
+	// The time since the first touch or reset
+	ActivationTime time.Time
+
+	// The x and y position of the finger when it first touched the
+	// pad. Unlike FirstX/FirstY this is not cleared by reset(), so
+	// it can be used to measure how far the finger has travelled
+	// since it touched down, for hold and tap detection.
+	OriginX    int
+	OriginY    int
+	HasOriginX bool
+	HasOriginY bool
+}
+
+func (finger *Finger) activate() {
+	finger.IsActive = true
+	finger.HasOriginX = false
+	finger.HasOriginY = false
+	finger.reset()
+}
+
+func (finger *Finger) deactivate() {
+	finger.IsActive = false
+}
+
+func (finger *Finger) reset() {
+
+	finger.ActivationTime = time.Now()
+	finger.HasPositionX = false
+	finger.HasPositionY = false
+}
+
+// Set the current x postion of the finger
+func (finger *Finger) setPositionX(x int) {
+
+	if !finger.HasPositionX {
+		finger.FirstX = x
+		finger.HasPositionX = true
+	}
+
+	if !finger.HasOriginX {
+		finger.OriginX = x
+		finger.HasOriginX = true
+	}
+
+	finger.LastX = x
+
+}
+
+// Set the current y postion of the finger
+func (finger *Finger) setPositionY(y int) {
+
+	if !finger.HasPositionY {
+		finger.FirstY = y
+		finger.HasPositionY = true
+	}
+
+	if !finger.HasOriginY {
+		finger.OriginY = y
+		finger.HasOriginY = true
+	}
+
+	finger.LastY = y
+
+}
+
+// If the position of the finger has been set after the first
+// touch or since the last reset
+func (finger *Finger) hasPosition() bool {
+	return finger.HasPositionX && finger.HasPositionY
+}
+
+// If the origin position of the finger has been set since it
+// touched down
+func (finger *Finger) hasOrigin() bool {
+	return finger.HasOriginX && finger.HasOriginY
+}
+
+// The distance the finger has moved relative to the position it
+// had when it first touched down, regardless of any resets since
+func (finger *Finger) getOriginDistance() int {
+
+	if !finger.hasOrigin() {
+		return 0
+	}
+
+	return calculateDistance(finger.LastX, finger.LastY, finger.OriginX, finger.OriginY)
+}
+
+// Return the angle (0-360 degrees) of the last movement of the finger since
+// relative to the x-axis since the
+func (finger *Finger) getAngle() int {
+
+	if !finger.hasPosition() {
+		return 0
+	}
+
+	dx := finger.LastX - finger.FirstX
+	dy := -(finger.LastY - finger.FirstY)
+
+	angle := int(math.Atan2(float64(dy), float64(dx)) * 180.0 / math.Pi)
+
+	if angle < 0 {
+		return 360 + angle
+	}
+	return angle
+
+}
+
+// Returns the direction of the movement of finger relative to the
+// first position since the first touch or reset.
+func (finger *Finger) getDirection() gestureType {
+
+	angle := finger.getAngle()
+
+	switch {
+
+	case angle >= 45 && angle <= 135:
+		return SWIPE_UP
+	case angle >= 135 && angle <= 225:
+		return SWIPE_LEFT
+	case angle >= 225 && angle <= 315:
+		return SWIPE_DOWN
+	case angle >= 315 || angle <= 45:
+		return SWIPE_RIGHT
+	default:
+		return UNKNOWN
+
+	}
+
+}
+
+// The distance the finger has moved relative to the
+// first postion of the finger since the first touch or reset
+func (finger *Finger) getDistance() int {
+
+	if !finger.hasPosition() {
+		return 0
+	}
+
+	return calculateDistance(finger.LastX, finger.LastY, finger.FirstX, finger.FirstY)
+}
+
+// Check if the finger has has a movement that is considered a swipeing motion.
+func (finger *Finger) hasSwiped(distanceThreshold int, checkDelay time.Duration) bool {
+
+	if !finger.hasPosition() {
+		return false
+	}
+
+	if diff := time.Since(finger.ActivationTime); diff < checkDelay {
+		return false
+	}
+
+	return finger.getDistance() > distanceThreshold
+
+}
+
+/*
+EventHandler ========================
+*/
+type EventHandler struct {
+	// Five fingers
+	fingers [5]Finger
+	// The current finger that is modified by the events
+	currentSlot int
+	// The last reported gesture type
+	lastGesture gestureType
+	// The number of active fingers touching the pad
+	fingerCount int
+	// Used to keep track of the last check for a gesture
+	checkTimer time.Time
+	// Suspend any new gestures until the fingers are lifted
+	// from the pad. Used to prevent reporting of multiple gestures in
+	// one movement.
+	suspend bool
+	// Channel for detected gestures
+	gestures chan Gesture
+	// Channel for in-progress gesture events (begin/update/end)
+	updates chan GestureEvent
+
+	// The number of fingerCounts that have gestures
+	// defined in the config. There is no need to detect
+	// gestures if the no action is defined for that number
+	// of fingers tounching.
+	configuredFingers map[int]bool
+
+	// The time the first finger touched the pad, used to detect
+	// holds and taps. Unlike checkTimer this is not reset while
+	// the fingers are down.
+	touchDownTime time.Time
+	// The highest fingerCount seen since the first finger touched
+	// the pad, used as the finger count of a tap since fingerCount
+	// is already back at 0 once all the fingers have been lifted.
+	maxFingerCount int
+	// The largest distance any finger has moved from its origin
+	// since the first finger touched the pad, used to tell a tap
+	// from an accidental brush of the pad.
+	tapMaxDistance int
+
+	// The cumulative movement, scale and rotation of the fingers'
+	// centroid since touchDownTime, updated on every position event
+	// and reported on the updates channel.
+	progressDx, progressDy float64
+	progressScale          float64
+	progressAngle          float64
+
+	// The ABS_X/ABS_Y reporting range of the device, queried once at
+	// startup, used to normalize the touch-down position for region
+	// classification. Left zero if the device didn't report a usable
+	// range, in which case every gesture is classified as region "".
+	absXMin, absXMax int32
+	absYMin, absYMax int32
+	// The fraction (0..1) of the trackpad's width/height, from each
+	// edge, that counts as that edge's region.
+	edgeMargin float64
+
+	// The raw ABS_X/ABS_Y reported when the first finger touched
+	// down, and whether they have been captured yet for this touch.
+	originRegionX, originRegionY       int
+	hasOriginRegionX, hasOriginRegionY bool
+	// The region of the trackpad the current gesture started in, see
+	// Gesture.Region.
+	originRegion string
+
+	// The tunable gesture-detection thresholds, see Config.Device.
+	// Defaulted from DIST_SWIPE/DIST_OTHER/CHECK_DELAY/RESET_TIMEOUT
+	// in newEvdevBackend when left at zero in the config.
+	swipeDistance, otherDistance int
+	checkDelay, resetTimeout     time.Duration
+
+	// The custom compound path gestures configured, see
+	// CustomGesture and checkPath.
+	customGestures []CustomGesture
+	// The centroid position (see calculateCentroid) the current path
+	// segment started at, and whether it has been set for this touch.
+	pathVertexX, pathVertexY int
+	hasPathVertex            bool
+	// The swipe directions of the segments recorded so far during
+	// the current touch, see updatePathVertex.
+	pathSegments []gestureType
+}
+
+func (handler *EventHandler) resetFingers() {
+
+	for i := range handler.fingers {
+		handler.fingers[i].reset()
+	}
+	handler.checkTimer = time.Now()
+
+}
+
+func (handler *EventHandler) handleEvent(event *evdev.InputEvent) {
+
+	switch event.Type {
+
+	case evdev.EV_SYN:
+		handler.handleSynEvent(event)
+	case evdev.EV_ABS:
+		handler.handleAbsEvent(event)
+	}
+
+}
+
+func (handler *EventHandler) handleSynEvent(event *evdev.InputEvent) {
+
+	if event.Code != evdev.SYN_REPORT {
+		return
+	}
+
+	// Only the finger coordinates reported before this SYN_REPORT are
+	// mutually consistent, so progress is only recomputed once per
+	// frame here, not from handleAbsEvent's individual X/Y reports.
+	handler.updateProgress()
+
+	// Only check for gestures after a report event and not suspended
+	if !handler.suspend {
+		handler.detectGesture()
+	}
+
+}
+
+func (handler *EventHandler) detectGesture() {
+
+	// Time since last reset
+	timeDiff := time.Since(handler.checkTimer)
+
+	// Do we have enought finger or enought time passed since the last reset
+	if _, ok := handler.configuredFingers[handler.fingerCount]; !ok || handler.fingerCount < 2 || timeDiff < handler.checkDelay {
+		// fmt.Println(handler.fingerCount, timeDiff)
+		return
+	}
+
+	// If the fingers have rested close to where they touched down
+	// for long enough, report a hold instead of waiting for a
+	// swipe or pinch that will never come.
+	if handler.lastGesture == UNKNOWN && handler.checkHold() {
+		handler.emitGesture(Gesture{GestureType: HOLD, FingerCount: handler.fingerCount, Region: handler.originRegion})
+		handler.suspend = true
+		return
+	}
+
+	isSwipe := false
+	gesture := UNKNOWN
+
+	// We only register a swipe if all the fingers
+	// reports a swipe in the same direction
+	for i := range handler.fingers {
+
+		fing := &handler.fingers[i]
+
+		if !fing.IsActive {
+			continue
+		}
+
+		if fing.hasSwiped(handler.swipeDistance, handler.checkDelay) {
+			isSwipe = true
+			if i == 0 {
+				gesture = fing.getDirection()
+			} else if fing.getDirection() != gesture {
+				// Not all fingers moved in the same direction
+				// so its not a swipe
+				isSwipe = false
+				break
+			}
+		} else {
+
+			// Not all fingers has moved enough to register a swipe
+			// so we return
+
+			// If it has been more than some ms since the last reset and
+			// no gesture is detected we reset
+			if timeDiff > handler.resetTimeout {
+				handler.resetFingers()
+			}
+			return
+		}
+	}
+
+	if isSwipe {
+
+		if handler.lastGesture != gesture {
+
+			handler.emitGesture(Gesture{GestureType: gesture, FingerCount: handler.fingerCount, Region: handler.originRegion})
+			// Suspend gestures until fingers are lifted from the pad
+			handler.suspend = true
+		}
+
+	} else {
+
+		// It was not a straight swipe so check for other gestures
+		gesture := handler.calculateGesture()
+
+		if gesture != UNKNOWN {
+
+			if handler.lastGesture != gesture {
+
+				handler.emitGesture(Gesture{GestureType: gesture, FingerCount: handler.fingerCount, Region: handler.originRegion})
+				// Suspend gestures until fingers are lifted from the pad
+				handler.suspend = true
+
+			}
+
+		}
+	}
+
+	// When we get here we have either reported a swipe and is suspended or it was an unknown movement
+	// so we reset the fingers to check for a new gesture if the last movement was not a gesture
+	handler.resetFingers()
+
+}
+
+// Reports whether all the active fingers have rested within
+// DIST_OTHER of the position they touched down at for at least
+// HOLD_DURATION.
+func (handler *EventHandler) checkHold() bool {
+
+	if time.Since(handler.touchDownTime) < HOLD_DURATION {
+		return false
+	}
+
+	for i := range handler.fingers {
+
+		fing := &handler.fingers[i]
+
+		if !fing.IsActive {
+			continue
+		}
+
+		if !fing.hasOrigin() || fing.getOriginDistance() > handler.otherDistance {
+			return false
+		}
+
+	}
+
+	return true
+
+}
+
+func (handler *EventHandler) emitGesture(gesture Gesture) {
+
+	handler.lastGesture = gesture.GestureType
+	handler.gestures <- gesture
+
+}
+
+func (handler *EventHandler) handleAbsEvent(event *evdev.InputEvent) {
+
+	switch event.Code {
+
+	case evdev.ABS_MT_SLOT:
+		handler.currentSlot = int(event.Value)
+	case evdev.ABS_MT_TRACKING_ID:
+
+		prevCount := handler.fingerCount
+
+		if event.Value == -1 {
+			handler.fingerCount -= 1
+			handler.fingers[handler.currentSlot].deactivate()
+		} else {
+			handler.fingerCount += 1
+			handler.fingers[handler.currentSlot].activate()
+		}
+
+		handler.resetFingers()
+
+		// If previously no fingers was toucing we get
+		// ready to handle a new gesture
+		if prevCount == 0 {
+			handler.lastGesture = UNKNOWN
+			handler.suspend = false
+			handler.touchDownTime = time.Now()
+			handler.tapMaxDistance = 0
+			handler.progressDx, handler.progressDy = 0, 0
+			handler.progressScale = 1.0
+			handler.progressAngle = 0
+			handler.hasOriginRegionX = false
+			handler.hasOriginRegionY = false
+			handler.originRegion = ""
+			handler.hasPathVertex = false
+			handler.pathSegments = nil
+			handler.emitUpdate(GestureBegin, handler.fingerCount)
+		}
+
+		if handler.fingerCount > handler.maxFingerCount {
+			handler.maxFingerCount = handler.fingerCount
+		}
+
+		// All fingers have been lifted, check if the touch was
+		// short and still enough to be a tap
+		if handler.fingerCount == 0 && prevCount > 0 {
+			handler.checkPath()
+			handler.checkTap()
+			handler.emitUpdate(GestureEnd, handler.maxFingerCount)
+		}
+
+	case evdev.ABS_MT_POSITION_X:
+		handler.fingers[handler.currentSlot].setPositionX(int(event.Value))
+	case evdev.ABS_MT_POSITION_Y:
+		handler.fingers[handler.currentSlot].setPositionY(int(event.Value))
+
+	case evdev.ABS_X:
+		handler.setOriginRegionX(int(event.Value))
+	case evdev.ABS_Y:
+		handler.setOriginRegionY(int(event.Value))
+	}
+
+}
+
+// Capture the x position the first finger touched down at, and
+// classify the gesture's region once both axes are known. Ignored
+// after the first report for a touch, like Finger.OriginX.
+func (handler *EventHandler) setOriginRegionX(x int) {
+
+	if handler.hasOriginRegionX {
+		return
+	}
+
+	handler.originRegionX = x
+	handler.hasOriginRegionX = true
+	handler.updateOriginRegion()
+
+}
+
+// Capture the y position the first finger touched down at, see
+// setOriginRegionX.
+func (handler *EventHandler) setOriginRegionY(y int) {
+
+	if handler.hasOriginRegionY {
+		return
+	}
+
+	handler.originRegionY = y
+	handler.hasOriginRegionY = true
+	handler.updateOriginRegion()
+
+}
+
+// Classify which region of the trackpad the current gesture started
+// in, once both ABS_X and ABS_Y have been reported for it. Leaves
+// originRegion at "" if the device didn't report a usable ABS_X/ABS_Y
+// range.
+func (handler *EventHandler) updateOriginRegion() {
+
+	if !handler.hasOriginRegionX || !handler.hasOriginRegionY {
+		return
+	}
+
+	if handler.absXMax <= handler.absXMin || handler.absYMax <= handler.absYMin {
+		return
+	}
+
+	x := normalize(handler.originRegionX, int(handler.absXMin), int(handler.absXMax))
+	y := normalize(handler.originRegionY, int(handler.absYMin), int(handler.absYMax))
+
+	handler.originRegion = classifyRegion(x, y, handler.edgeMargin)
+
+}
+
+// Keep track of how far any finger has travelled from its origin
+// since the first finger touched down, and of the fingers' current
+// centroid movement/scale/rotation relative to where they touched
+// down, reporting the latter as a GestureUpdate. checkTap uses the
+// tracked distance to tell a tap from an accidental brush of the pad.
+func (handler *EventHandler) updateProgress() {
+
+	var originPositions, currentPositions [][2]int
+
+	for i := range handler.fingers {
+
+		fing := &handler.fingers[i]
+
+		if !fing.IsActive || !fing.hasOrigin() {
+			continue
+		}
+
+		if dist := fing.getOriginDistance(); dist > handler.tapMaxDistance {
+			handler.tapMaxDistance = dist
+		}
+
+		originPositions = append(originPositions, [2]int{fing.OriginX, fing.OriginY})
+		currentPositions = append(currentPositions, [2]int{fing.LastX, fing.LastY})
+
+	}
+
+	if len(originPositions) == 0 {
+		return
+	}
+
+	originCenter := calculateCentroid(originPositions)
+	currentCenter := calculateCentroid(currentPositions)
+
+	handler.updatePathVertex(currentCenter[0], currentCenter[1])
+
+	handler.progressDx = float64(currentCenter[0] - originCenter[0])
+	handler.progressDy = float64(-(currentCenter[1] - originCenter[1]))
+	handler.progressScale = 1.0
+	handler.progressAngle = 0
+
+	if len(originPositions) >= 2 {
+		if circ := calculateCircumference(originPositions); circ > 0 {
+			handler.progressScale = float64(calculateCircumference(currentPositions)) / float64(circ)
+		}
+		handler.progressAngle = calculateRotation(originPositions, currentPositions)
+	}
+
+	handler.emitUpdate(GestureUpdate, handler.fingerCount)
+
+}
+
+// Record a new segment of the current compound path gesture once the
+// fingers' centroid has moved pathSegmentThreshold from the last
+// recorded vertex, in the new cardinal direction. Segments are
+// matched against the configured CustomGestures on finger lift by
+// checkPath. This is a simplified, quantized stand-in for tracking
+// each segment's exact turn angle: a new segment is only recorded
+// once its direction lands in a different 90-degree quadrant than the
+// previous one, rather than on every ~60-degree turn.
+func (handler *EventHandler) updatePathVertex(x, y int) {
+
+	if !handler.hasPathVertex {
+		handler.pathVertexX, handler.pathVertexY = x, y
+		handler.hasPathVertex = true
+		return
+	}
+
+	dx := float64(x - handler.pathVertexX)
+	dy := float64(-(y - handler.pathVertexY))
+
+	if calculateDistance(x, y, handler.pathVertexX, handler.pathVertexY) < handler.otherDistance {
+		return
+	}
+
+	direction := directionFromDelta(dx, dy)
+	if direction == UNKNOWN {
+		return
+	}
+
+	if len(handler.pathSegments) == 0 || handler.pathSegments[len(handler.pathSegments)-1] != direction {
+		handler.pathSegments = append(handler.pathSegments, direction)
+	}
+
+	handler.pathVertexX, handler.pathVertexY = x, y
+
+}
+
+// Send a GestureEvent carrying the current progress state on the
+// updates channel.
+func (handler *EventHandler) emitUpdate(kind GestureEventKind, fingerCount int) {
+
+	handler.updates <- GestureEvent{
+		Kind:        kind,
+		FingerCount: fingerCount,
+		Dx:          handler.progressDx,
+		Dy:          handler.progressDy,
+		Scale:       handler.progressScale,
+		Angle:       handler.progressAngle,
+		Region:      handler.originRegion,
+	}
+
+}
+
+// Called once the last finger has been lifted from the pad. Reports
+// a TAP gesture if the whole touch was short and didn't move enough
+// to already have been reported as some other gesture.
+func (handler *EventHandler) checkTap() {
+
+	if handler.lastGesture != UNKNOWN || handler.maxFingerCount < 2 {
+		return
+	}
+
+	if time.Since(handler.touchDownTime) >= TAP_DURATION {
+		return
+	}
+
+	if handler.tapMaxDistance >= handler.otherDistance {
+		return
+	}
+
+	handler.emitGesture(Gesture{GestureType: TAP, FingerCount: handler.maxFingerCount, Region: handler.originRegion})
+
+}
+
+// Called once the last finger has been lifted from the pad. Reports
+// a PATH gesture if the segments recorded during the touch (see
+// updatePathVertex) match one of the configured custom gestures for
+// this finger count.
+func (handler *EventHandler) checkPath() {
+
+	if handler.lastGesture != UNKNOWN || len(handler.pathSegments) == 0 {
+		return
+	}
+
+	for _, custom := range handler.customGestures {
+
+		if custom.Fingers != handler.maxFingerCount || len(custom.Segments) != len(handler.pathSegments) {
+			continue
+		}
+
+		matches := true
+		for i, direction := range custom.Segments {
+			if directionName(handler.pathSegments[i]) != direction {
+				matches = false
+				break
+			}
+		}
+
+		if matches {
+			handler.emitGesture(Gesture{GestureType: PATH, FingerCount: handler.maxFingerCount, Path: custom.Name, Region: handler.originRegion})
+			return
+		}
+
+	}
+
+}
+
+// Returns the gesture type from the last movement of the
+// fingers if it was not a stright swipe to one of the sides
+func (handler *EventHandler) calculateGesture() gestureType {
+
+	var startPositions [][2]int
+	var endPositions [][2]int
+
+	for i := range handler.fingers {
+
+		fing := &handler.fingers[i]
+
+		if !fing.IsActive {
+			continue
+		}
+		if !fing.hasSwiped(handler.otherDistance, handler.checkDelay) {
+			return UNKNOWN
+		}
+
+		startPositions = append(startPositions, [2]int{fing.FirstX, fing.FirstY})
+		endPositions = append(endPositions, [2]int{fing.LastX, fing.LastY})
+
+	}
+
+	// If the fingers rotated around their centroid more than
+	// ROTATE_THRESHOLD degrees on average, report that instead of
+	// a pinch/spread.
+	if rotation := calculateRotation(startPositions, endPositions); math.Abs(rotation) >= ROTATE_THRESHOLD {
+		if rotation > 0 {
+			return ROTATE_CCW
+		}
+		return ROTATE_CW
+	}
+
+	// Calculate the circumference around the fingers in the start and end position
+	// to determine if the fingers was pinched or spread.
+	start := calculateCircumference(startPositions)
+	end := calculateCircumference(endPositions)
+
+	if start > end {
+		return PINCH
+	} else {
+		return SPREAD
+	}
+
+}
+
+// Listen for event from the input device
+func (handler *EventHandler) run(dev *evdev.InputDevice) {
+
+	var events []evdev.InputEvent
+
+	for {
+		events, _ = dev.Read()
+		for i := range events {
+
+			handler.handleEvent(&events[i])
+
+		}
+
+	}
+
+}
+
+/*
+	Helper functions =====================
+*/
+
+// Calculate the circumference around the points
+func calculateCircumference(points [][2]int) int {
+
+	if len(points) == 2 {
+		return calculateDistance(points[0][0], points[0][1], points[1][0], points[1][1])
+	}
+
+	total := 0
+	p0 := points[0]
+
+	for _, p := range points {
+		total += calculateDistance(p0[0], p0[1], p[0], p[1])
+		p0 = p
+	}
+
+	total += calculateDistance(points[0][0], points[0][1], p0[0], p0[1])
+
+	return total
+
+}
+
+// Calculate distance between two points
+func calculateDistance(x0, y0, x, y int) int {
+
+	return int(math.Sqrt(math.Pow(float64(x-x0), 2) +
+		math.Pow(float64(y-y0), 2)))
+
+}
+
+// Calculate the average signed rotation (in degrees) of a set of
+// fingers, comparing each finger's vector from the centroid at its
+// start position to its vector from the centroid at its end
+// position. A positive value means the fingers rotated
+// counter-clockwise.
+func calculateRotation(start, end [][2]int) float64 {
+
+	startCenter := calculateCentroid(start)
+	endCenter := calculateCentroid(end)
+
+	total := 0.0
+
+	for i := range start {
+
+		delta := angleBetween(
+			start[i][0]-startCenter[0], start[i][1]-startCenter[1],
+			end[i][0]-endCenter[0], end[i][1]-endCenter[1],
+		)
+
+		total += delta
+
+	}
+
+	return total / float64(len(start))
+
+}
+
+// The centroid (average position) of a set of points
+func calculateCentroid(points [][2]int) [2]int {
+
+	var x, y int
+
+	for _, p := range points {
+		x += p[0]
+		y += p[1]
+	}
+
+	return [2]int{x / len(points), y / len(points)}
+
+}
+
+// The signed angle (in degrees, normalized to (-180, 180]) between
+// vector (x0, y0) and vector (x1, y1), in the same up-is-positive
+// orientation as Finger.getAngle.
+func angleBetween(x0, y0, x1, y1 int) float64 {
+
+	a0 := math.Atan2(float64(-y0), float64(x0))
+	a1 := math.Atan2(float64(-y1), float64(x1))
+
+	delta := (a1 - a0) * 180.0 / math.Pi
+
+	for delta > 180 {
+		delta -= 360
+	}
+	for delta <= -180 {
+		delta += 360
+	}
+
+	return delta
+
+}
+
+// Scale value from the range [min, max] to a fraction in [0, 1].
+func normalize(value, min, max int) float64 {
+
+	if max <= min {
+		return 0.5
+	}
+
+	return float64(value-min) / float64(max-min)
+
+}
+
+// classifyRegion returns which edge or corner of the trackpad (x, y)
+// -- normalized to [0, 1] -- is within marginFrac of, as the region
+// code used to qualify a gesture's config lookup key (e.g. "L" for
+// "Swipe3Right@L"). Returns "" ("none") if the point is not within
+// marginFrac of any edge.
+func classifyRegion(x, y, marginFrac float64) string {
+
+	left := x <= marginFrac
+	right := x >= 1-marginFrac
+	top := y <= marginFrac
+	bottom := y >= 1-marginFrac
+
+	switch {
+	case left && top:
+		return "TL"
+	case left && bottom:
+		return "BL"
+	case right && top:
+		return "TR"
+	case right && bottom:
+		return "BR"
+	case left:
+		return "L"
+	case right:
+		return "R"
+	case top:
+		return "T"
+	case bottom:
+		return "B"
+	default:
+		return ""
+	}
+
+}
+
+// The kernel's input_absinfo struct, read directly with an EVIOCGABS
+// ioctl since golang-evdev does not expose one.
+type absInfo struct {
+	Value, Minimum, Maximum, Fuzz, Flat, Resolution int32
+}
+
+// Query the [minimum, maximum] range reported by the device for an
+// ABS_* axis.
+func queryAbsInfo(dev *evdev.InputDevice, code int) (min, max int32, err error) {
+
+	var info absInfo
+
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL,
+		dev.File.Fd(),
+		uintptr(evdev.EVIOCGABS(code)),
+		uintptr(unsafe.Pointer(&info)))
+
+	if errno != 0 {
+		return 0, 0, errno
+	}
+
+	return info.Minimum, info.Maximum, nil
+
+}
+
+/*
+	evdevBackend ========================
+*/
+
+// evdevBackend is the original Backend implementation. It reads
+// ABS_MT_* events directly from the kernel input device and runs its
+// own finger tracking and gesture detection. Kept around (and still
+// the default) for setups where libinput is not available or where a
+// user wants the previous behaviour.
+type evdevBackend struct {
+	handler *EventHandler
+	dev     *evdev.InputDevice
+}
+
+func newEvdevBackend(cfg *Config) (Backend, error) {
+
+	if len(cfg.Device.Path) == 0 {
+		return nil, fmt.Errorf("no input device path in config")
+	}
+
+	dev, err := evdev.Open(cfg.Device.Path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	edgeMargin := cfg.Device.EdgeMargin
+	if edgeMargin <= 0 {
+		edgeMargin = 0.1
+	}
+
+	swipeDistance := cfg.Device.SwipeDistance
+	if swipeDistance <= 0 {
+		swipeDistance = DIST_SWIPE
+	}
+
+	otherDistance := cfg.Device.OtherDistance
+	if otherDistance <= 0 {
+		otherDistance = DIST_OTHER
+	}
+
+	checkDelay := CHECK_DELAY * time.Millisecond
+	if cfg.Device.CheckDelay > 0 {
+		checkDelay = time.Duration(cfg.Device.CheckDelay) * time.Millisecond
+	}
+
+	resetTimeout := RESET_TIMEOUT * time.Millisecond
+	if cfg.Device.ResetTimeout > 0 {
+		resetTimeout = time.Duration(cfg.Device.ResetTimeout) * time.Millisecond
+	}
+
+	handler := &EventHandler{
+		gestures:          make(chan Gesture),
+		updates:           make(chan GestureEvent),
+		configuredFingers: getConfiguredFingers(cfg),
+		edgeMargin:        edgeMargin,
+		swipeDistance:     swipeDistance,
+		otherDistance:     otherDistance,
+		checkDelay:        checkDelay,
+		resetTimeout:      resetTimeout,
+		customGestures:    cfg.Gestures,
+	}
+
+	// If the device doesn't report a usable ABS_X/ABS_Y range this is
+	// left at zero, and every gesture is classified as region "".
+	if xMin, xMax, err := queryAbsInfo(dev, evdev.ABS_X); err == nil {
+		handler.absXMin, handler.absXMax = xMin, xMax
+	}
+	if yMin, yMax, err := queryAbsInfo(dev, evdev.ABS_Y); err == nil {
+		handler.absYMin, handler.absYMax = yMin, yMax
+	}
+
+	return &evdevBackend{
+		handler: handler,
+		dev:     dev,
+	}, nil
+
+}
+
+func (backend *evdevBackend) Gestures() chan Gesture {
+	return backend.handler.gestures
+}
+
+func (backend *evdevBackend) Updates() chan GestureEvent {
+	return backend.handler.updates
+}
+
+func (backend *evdevBackend) Run() error {
+	backend.handler.run(backend.dev)
+	return nil
+}
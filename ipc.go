@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+
+	"github.com/BurntSushi/toml"
+)
+
+// ControlServer exports detected gestures and lifecycle events on a Unix
+// socket as JSON lines, and accepts commands to control the running
+// tpswipe instance without restarting it: reload-config, pause, resume,
+// simulate-gesture and list-bindings. This lets desktop shells and status
+// bars react to gestures in real time, and lets simulate-gesture drive
+// the configured actions the same way --test prints them, without
+// physically touching the pad.
+//
+// A ControlServer is always dereferenced through its own nil-safe
+// methods, so main can keep a possibly-nil *ControlServer around and
+// call them unconditionally when Config.Control.Socket is empty.
+type ControlServer struct {
+	listener   net.Listener
+	configPath string
+	simulate   chan Gesture
+
+	mu     sync.RWMutex
+	cfg    *Config
+	paused bool
+
+	clientsMu sync.Mutex
+	clients   map[*controlClient]struct{}
+}
+
+// controlClient is one connection to the control socket.
+type controlClient struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+func (client *controlClient) send(v interface{}) error {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	return client.enc.Encode(v)
+}
+
+// controlEvent is one line of the broadcast stream: a detected gesture,
+// an in-progress gesture update, or a lifecycle notice.
+type controlEvent struct {
+	Type    string        `json:"type"`
+	Gesture *Gesture      `json:"gesture,omitempty"`
+	Update  *GestureEvent `json:"update,omitempty"`
+}
+
+// controlRequest is one command read from a client connection.
+type controlRequest struct {
+	Command string `json:"command"`
+	// Fields used by the "simulate-gesture" command.
+	Type    string `json:"type"`
+	Fingers int    `json:"fingers"`
+	Region  string `json:"region"`
+}
+
+// controlResponse is the reply sent back for a controlRequest. Since a
+// client's connection also carries broadcast controlEvents (a client
+// issuing "pause" is, after all, still subscribed to the "paused" event
+// its own command causes), Type is always "response" so a client can
+// tell the two apart by that field alone.
+type controlResponse struct {
+	Type     string    `json:"type"`
+	OK       bool      `json:"ok"`
+	Error    string    `json:"error,omitempty"`
+	Bindings []Binding `json:"bindings,omitempty"`
+}
+
+// NewControlServer listens on path, which is removed first if a stale
+// socket was left behind by a previous run. cfg is the live
+// configuration; reload-config replaces its Bindings and Gestures in
+// place (Device is left untouched, since the backend can't be swapped
+// without restarting).
+func NewControlServer(path, configPath string, cfg *Config) (*ControlServer, error) {
+
+	os.Remove(path)
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", path, err)
+	}
+
+	return &ControlServer{
+		listener:   listener,
+		configPath: configPath,
+		simulate:   make(chan Gesture),
+		cfg:        cfg,
+		clients:    make(map[*controlClient]struct{}),
+	}, nil
+
+}
+
+// Run accepts client connections until the listener is closed.
+func (server *ControlServer) Run() error {
+
+	if server == nil {
+		return nil
+	}
+
+	for {
+
+		conn, err := server.listener.Accept()
+		if err != nil {
+			return err
+		}
+
+		go server.handleConn(conn)
+
+	}
+
+}
+
+// Close stops accepting connections and removes the socket file.
+func (server *ControlServer) Close() error {
+	if server == nil {
+		return nil
+	}
+	return server.listener.Close()
+}
+
+// simulated returns the channel simulate-gesture commands deliver
+// gestures on. It is safe to call on a nil *ControlServer, returning a
+// nil channel so a `case gest := <-control.simulated():` select arm
+// simply never fires.
+func (server *ControlServer) simulated() chan Gesture {
+	if server == nil {
+		return nil
+	}
+	return server.simulate
+}
+
+// isPaused reports whether the "pause" command has disabled running
+// bound commands. Always false on a nil *ControlServer.
+func (server *ControlServer) isPaused() bool {
+	if server == nil {
+		return false
+	}
+	server.mu.RLock()
+	defer server.mu.RUnlock()
+	return server.paused
+}
+
+// currentConfig returns the live config to handle a gesture with: a
+// snapshot of the control socket's config if one is set up, or fallback
+// otherwise. The snapshot is a shallow copy taken under lock, so the
+// caller can use it afterwards without holding the lock while a
+// reload-config command swaps in a new one.
+func (server *ControlServer) currentConfig(fallback *Config) *Config {
+	if server == nil {
+		return fallback
+	}
+	server.mu.RLock()
+	defer server.mu.RUnlock()
+	cfg := *server.cfg
+	return &cfg
+}
+
+// broadcastGesture publishes a detected gesture to every connected
+// client. A no-op on a nil *ControlServer.
+func (server *ControlServer) broadcastGesture(gest Gesture) {
+	if server == nil {
+		return
+	}
+	server.broadcast(controlEvent{Type: "gesture", Gesture: &gest})
+}
+
+// broadcastUpdate publishes an in-progress gesture event to every
+// connected client. A no-op on a nil *ControlServer.
+func (server *ControlServer) broadcastUpdate(event GestureEvent) {
+	if server == nil {
+		return
+	}
+	server.broadcast(controlEvent{Type: "update", Update: &event})
+}
+
+func (server *ControlServer) broadcast(event controlEvent) {
+
+	server.clientsMu.Lock()
+	defer server.clientsMu.Unlock()
+
+	for client := range server.clients {
+		if err := client.send(event); err != nil {
+			delete(server.clients, client)
+		}
+	}
+
+}
+
+func (server *ControlServer) handleConn(conn net.Conn) {
+
+	client := &controlClient{enc: json.NewEncoder(conn)}
+
+	server.clientsMu.Lock()
+	server.clients[client] = struct{}{}
+	server.clientsMu.Unlock()
+
+	defer func() {
+		server.clientsMu.Lock()
+		delete(server.clients, client)
+		server.clientsMu.Unlock()
+		conn.Close()
+	}()
+
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+
+		var req controlRequest
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			client.send(controlResponse{Type: "response", Error: fmt.Sprintf("invalid command: %s", err)})
+			continue
+		}
+
+		resp := server.handleRequest(&req)
+		resp.Type = "response"
+		client.send(resp)
+
+	}
+
+}
+
+func (server *ControlServer) handleRequest(req *controlRequest) controlResponse {
+
+	switch req.Command {
+
+	case "reload-config":
+		return server.reloadConfig()
+
+	case "pause":
+		server.mu.Lock()
+		server.paused = true
+		server.mu.Unlock()
+		server.broadcast(controlEvent{Type: "paused"})
+		return controlResponse{OK: true}
+
+	case "resume":
+		server.mu.Lock()
+		server.paused = false
+		server.mu.Unlock()
+		server.broadcast(controlEvent{Type: "resumed"})
+		return controlResponse{OK: true}
+
+	case "simulate-gesture":
+		gestureType := gestureTypeFromName(req.Type)
+		if gestureType == UNKNOWN {
+			return controlResponse{Error: fmt.Sprintf("unknown gesture type: %s", req.Type)}
+		}
+		server.simulate <- Gesture{GestureType: gestureType, FingerCount: req.Fingers, Region: req.Region}
+		return controlResponse{OK: true}
+
+	case "list-bindings":
+		server.mu.RLock()
+		defer server.mu.RUnlock()
+		return controlResponse{OK: true, Bindings: server.cfg.Bindings}
+
+	default:
+		return controlResponse{Error: fmt.Sprintf("unknown command: %s", req.Command)}
+
+	}
+
+}
+
+// reloadConfig re-reads configPath and replaces the live Bindings and
+// Gestures with it. Device is left untouched, since the backend it
+// configures can't be swapped without restarting tpswipe -- this also
+// means a new binding for a finger count nothing was previously bound to
+// won't fire, since the evdev backend only reports the finger counts
+// getConfiguredFingers saw at startup.
+func (server *ControlServer) reloadConfig() controlResponse {
+
+	var cfg Config
+	if _, err := toml.DecodeFile(server.configPath, &cfg); err != nil {
+		return controlResponse{Error: fmt.Sprintf("config error: %s", err)}
+	}
+
+	server.mu.Lock()
+	server.cfg.Bindings = cfg.Bindings
+	server.cfg.Gestures = cfg.Gestures
+	server.mu.Unlock()
+
+	server.broadcast(controlEvent{Type: "reloaded"})
+
+	return controlResponse{OK: true}
+
+}
@@ -0,0 +1,238 @@
+//go:build libinput
+
+package main
+
+/*
+#cgo LDFLAGS: -linput -ludev
+#include <stdlib.h>
+#include <fcntl.h>
+#include <unistd.h>
+#include <poll.h>
+#include <errno.h>
+#include <libinput.h>
+#include <libudev.h>
+
+static int tpswipe_open_restricted(const char *path, int flags, void *user_data) {
+	int fd = open(path, flags);
+	return fd < 0 ? -errno : fd;
+}
+
+static void tpswipe_close_restricted(int fd, void *user_data) {
+	close(fd);
+}
+
+static const struct libinput_interface tpswipe_interface = {
+	.open_restricted = tpswipe_open_restricted,
+	.close_restricted = tpswipe_close_restricted,
+};
+
+static struct libinput *tpswipe_libinput_new(struct udev *udev) {
+	return libinput_udev_create_context(&tpswipe_interface, NULL, udev);
+}
+
+static int tpswipe_poll(int fd) {
+	struct pollfd fds = { .fd = fd, .events = POLLIN };
+	return poll(&fds, 1, -1);
+}
+*/
+import "C"
+
+import (
+	"fmt"
+	"math"
+	"unsafe"
+)
+
+// libinputBackend lets libinput do the gesture detection instead of
+// parsing ABS_MT_* events ourselves. libinput already tells swipes,
+// pinches and holds apart, does palm rejection and works the same way
+// on both X11 and Wayland, none of which the evdevBackend can do.
+type libinputBackend struct {
+	li       *C.struct_libinput
+	udev     *C.struct_udev
+	gestures chan Gesture
+	updates  chan GestureEvent
+}
+
+func newLibinputBackend(cfg *Config) (Backend, error) {
+
+	seat := cfg.Device.Seat
+	if len(seat) == 0 {
+		seat = "seat0"
+	}
+
+	udev := C.udev_new()
+	if udev == nil {
+		return nil, fmt.Errorf("failed to create udev context")
+	}
+
+	li := C.tpswipe_libinput_new(udev)
+	if li == nil {
+		C.udev_unref(udev)
+		return nil, fmt.Errorf("failed to create libinput context")
+	}
+
+	cSeat := C.CString(seat)
+	defer C.free(unsafe.Pointer(cSeat))
+
+	if C.libinput_udev_assign_seat(li, cSeat) != 0 {
+		C.libinput_unref(li)
+		C.udev_unref(udev)
+		return nil, fmt.Errorf("failed to assign seat %q to libinput", seat)
+	}
+
+	return &libinputBackend{
+		li:       li,
+		udev:     udev,
+		gestures: make(chan Gesture),
+		updates:  make(chan GestureEvent),
+	}, nil
+
+}
+
+func (backend *libinputBackend) Gestures() chan Gesture {
+	return backend.gestures
+}
+
+func (backend *libinputBackend) Updates() chan GestureEvent {
+	return backend.updates
+}
+
+// Run dispatches libinput events until the context is destroyed or an
+// error occurs. Each in-progress gesture is tracked in a
+// libinputGesture and turned into a Gesture once it ends.
+func (backend *libinputBackend) Run() error {
+
+	fd := C.libinput_get_fd(backend.li)
+	if fd < 0 {
+		return fmt.Errorf("failed to get libinput fd")
+	}
+
+	tracker := &libinputGesture{}
+
+	for {
+
+		if C.tpswipe_poll(fd) < 0 {
+			return fmt.Errorf("poll on libinput fd failed")
+		}
+
+		if C.libinput_dispatch(backend.li) != 0 {
+			return fmt.Errorf("libinput_dispatch failed")
+		}
+
+		for {
+
+			event := C.libinput_get_event(backend.li)
+			if event == nil {
+				break
+			}
+
+			backend.handleEvent(event, tracker)
+			C.libinput_event_destroy(event)
+
+		}
+
+	}
+
+}
+
+// libinputGesture accumulates the deltas of an in-progress libinput
+// gesture so it can be turned into a Gesture once it ends.
+type libinputGesture struct {
+	fingerCount int
+	dx, dy      float64
+	scale       float64
+	angle       float64
+}
+
+func (backend *libinputBackend) handleEvent(event *C.struct_libinput_event, tracker *libinputGesture) {
+
+	switch C.libinput_event_get_type(event) {
+
+	case C.LIBINPUT_EVENT_GESTURE_SWIPE_BEGIN:
+		gest := C.libinput_event_get_gesture_event(event)
+		*tracker = libinputGesture{fingerCount: int(C.libinput_event_gesture_get_finger_count(gest))}
+		backend.emitUpdate(GestureBegin, tracker)
+
+	case C.LIBINPUT_EVENT_GESTURE_SWIPE_UPDATE:
+		gest := C.libinput_event_get_gesture_event(event)
+		tracker.dx += float64(C.libinput_event_gesture_get_dx(gest))
+		tracker.dy += float64(C.libinput_event_gesture_get_dy(gest))
+		backend.emitUpdate(GestureUpdate, tracker)
+
+	case C.LIBINPUT_EVENT_GESTURE_SWIPE_END:
+		if direction := directionFromDelta(tracker.dx, tracker.dy); direction != UNKNOWN {
+			// libinput doesn't report a gesture's starting position,
+			// so Region is always "" under this backend -- @-qualified
+			// action bindings only ever match their unqualified entry.
+			backend.gestures <- Gesture{GestureType: direction, FingerCount: tracker.fingerCount}
+		}
+		backend.emitUpdate(GestureEnd, tracker)
+
+	case C.LIBINPUT_EVENT_GESTURE_PINCH_BEGIN:
+		gest := C.libinput_event_get_gesture_event(event)
+		*tracker = libinputGesture{fingerCount: int(C.libinput_event_gesture_get_finger_count(gest)), scale: 1.0}
+		backend.emitUpdate(GestureBegin, tracker)
+
+	case C.LIBINPUT_EVENT_GESTURE_PINCH_UPDATE:
+		gest := C.libinput_event_get_gesture_event(event)
+		tracker.scale = float64(C.libinput_event_gesture_get_scale(gest))
+		// libinput's angle delta is positive for clockwise rotation,
+		// the opposite of calculateRotation's convention in
+		// evdev_backend.go, so it's negated here to keep ROTATE_CW/
+		// ROTATE_CCW and the broadcast GestureEvent.Angle consistent
+		// between backends.
+		tracker.angle -= float64(C.libinput_event_gesture_get_angle_delta(gest))
+		backend.emitUpdate(GestureUpdate, tracker)
+
+	case C.LIBINPUT_EVENT_GESTURE_PINCH_END:
+		if tracker.scale > 0 {
+
+			var gesture gestureType
+
+			switch {
+			case math.Abs(tracker.angle) >= ROTATE_THRESHOLD && tracker.angle > 0:
+				gesture = ROTATE_CCW
+			case math.Abs(tracker.angle) >= ROTATE_THRESHOLD:
+				gesture = ROTATE_CW
+			case tracker.scale < 1.0:
+				gesture = PINCH
+			default:
+				gesture = SPREAD
+			}
+
+			backend.gestures <- Gesture{GestureType: gesture, FingerCount: tracker.fingerCount}
+		}
+		backend.emitUpdate(GestureEnd, tracker)
+
+	case C.LIBINPUT_EVENT_GESTURE_HOLD_BEGIN:
+		gest := C.libinput_event_get_gesture_event(event)
+		*tracker = libinputGesture{fingerCount: int(C.libinput_event_gesture_get_finger_count(gest))}
+
+	case C.LIBINPUT_EVENT_GESTURE_HOLD_END:
+		gest := C.libinput_event_get_gesture_event(event)
+		if C.libinput_event_gesture_get_cancelled(gest) == 0 {
+			backend.gestures <- Gesture{GestureType: HOLD, FingerCount: tracker.fingerCount}
+		}
+
+	}
+
+}
+
+// emitUpdate turns the current state of an in-progress gesture into a
+// GestureEvent so progressive actions (zooming, scrubbing, workspace
+// previews) can follow it as it happens rather than waiting for it to end.
+func (backend *libinputBackend) emitUpdate(kind GestureEventKind, tracker *libinputGesture) {
+	scale := tracker.scale
+	if scale == 0 {
+		scale = 1.0
+	}
+	backend.updates <- GestureEvent{
+		Kind:        kind,
+		FingerCount: tracker.fingerCount,
+		Dx:          tracker.dx,
+		Dy:          tracker.dy,
+		Scale:       scale,
+		Angle:       tracker.angle,
+	}
+}
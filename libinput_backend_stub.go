@@ -0,0 +1,12 @@
+//go:build !libinput
+
+package main
+
+import "fmt"
+
+// newLibinputBackend is a stub used when tpswipe is built without the
+// "libinput" build tag, so the default build does not require cgo or
+// the libinput development headers.
+func newLibinputBackend(cfg *Config) (Backend, error) {
+	return nil, fmt.Errorf("libinput backend not compiled in; rebuild with -tags libinput")
+}
@@ -1,22 +1,17 @@
 package main
 
 import (
-	"gopkg.in/gcfg.v1"
 	"flag"
 	"fmt"
+	"github.com/BurntSushi/toml"
 	"github.com/BurntSushi/xgbutil"
 	"github.com/BurntSushi/xgbutil/ewmh"
 	"github.com/BurntSushi/xgbutil/icccm"
-	"github.com/gvalkov/golang-evdev"
 	"github.com/mattn/go-shellwords"
 	"math"
 	"os"
 	"os/exec"
 	"os/user"
-	"reflect"
-	"strconv"
-	"strings"
-	"time"
 )
 
 type gestureType int
@@ -29,627 +24,519 @@ const (
 	SWIPE_RIGHT
 	PINCH
 	SPREAD
-)
-
-const (
-	DIST_SWIPE  = 1100 // The distance the finger must move to trigger a swipe (unknown unit)
-	DIST_OTHER  = 500  // The distance the finger must move to trigger other gestures (unknown unit)
-	CHECK_DELAY = 50   // Delay between checking for gestures (ms)
+	HOLD
+	TAP
+	ROTATE_CW
+	ROTATE_CCW
+	PATH
 )
 
 type Gesture struct {
 	GestureType gestureType
 	FingerCount int
+	// The region of the trackpad the gesture started in, as
+	// classified by classifyRegion, e.g. "L" or "BR". Empty if the
+	// gesture started away from every edge, or if the backend
+	// doesn't report a starting position.
+	Region string
+	// The name of the matched Gesture config entry, only set when
+	// GestureType is PATH. See EventHandler.checkPath.
+	Path string
 }
 
 func (gest Gesture) String() string {
 
-	return fmt.Sprintf("%s(%d)", getGestureTypeName(gest.GestureType), gest.FingerCount)
-
-}
-
-/*
-	Finger struct
-*/
+	name := getGestureTypeName(gest.GestureType)
+	if gest.GestureType == PATH {
+		name = fmt.Sprintf("%s(%s)", name, gest.Path)
+	}
 
-type Finger struct {
-	// The first x and y postion that is reported after the finger touches the pad
-	// or after the finger has been reset
-	FirstX int
-	FirstY int
-	// The last x and y postion that is reported while the fingers is touching the pad
-	LastX int
-	LastY int
-	// If the position has been set after the first touch or reset
-	HasPositionX bool
-	HasPositionY bool
-	// If this finger is currently touching the pas
-	IsActive bool
-	// The time since the first touch or reset
-	ActivationTime time.Time
-}
+	if len(gest.Region) > 0 {
+		return fmt.Sprintf("%s(%d)@%s", name, gest.FingerCount, gest.Region)
+	}
+	return fmt.Sprintf("%s(%d)", name, gest.FingerCount)
 
-func (finger *Finger) activate() {
-	finger.IsActive = true
-	finger.reset()
 }
 
-func (finger *Finger) deactivate() {
-	finger.IsActive = false
-}
+// GestureEventKind identifies which phase of an in-progress gesture a
+// GestureEvent describes.
+type GestureEventKind int
 
-func (finger *Finger) reset() {
+const (
+	GestureBegin GestureEventKind = iota
+	GestureUpdate
+	GestureEnd
+)
 
-	finger.ActivationTime = time.Now()
-	finger.HasPositionX = false
-	finger.HasPositionY = false
+// GestureEvent reports one phase of an in-progress gesture, mirroring
+// libinput's begin/update/end model. Unlike Gesture, which is only
+// reported once a finished gesture has been classified, a GestureEvent
+// is streamed continuously while the fingers are moving so an action
+// can be driven by the gesture's progress instead of firing once it
+// has ended, e.g. a pinch smoothly scrubbing a zoom level.
+type GestureEvent struct {
+	Kind        GestureEventKind
+	FingerCount int
+	// Cumulative movement of the fingers' centroid since GestureBegin
+	Dx, Dy float64
+	// Cumulative pinch/spread scale factor since GestureBegin.
+	// 1.0 means no change, <1.0 a pinch and >1.0 a spread.
+	Scale float64
+	// Cumulative rotation in degrees since GestureBegin
+	Angle float64
+	// The region of the trackpad the gesture started in, see
+	// Gesture.Region.
+	Region string
 }
 
-// Set the current x postion of the finger
-func (finger *Finger) setPositionX(x int) {
-
-	if !finger.HasPositionX {
-		finger.FirstX = x
-		finger.HasPositionX = true
-	}
-
-	finger.LastX = x
-
-}
+/*
+	Backend ========================
+*/
 
-// Set the current y postion of the finger
-func (finger *Finger) setPositionY(y int) {
+// Backend reads gesture events from an input source, detects the
+// gesture being performed and reports finished gestures on its
+// Gestures channel. tpswipe ships with an evdevBackend that parses
+// ABS_MT_* events directly and a libinputBackend that lets libinput
+// do the detection instead.
+type Backend interface {
+	// Run starts reading events from the device. It blocks until
+	// the device is closed or an unrecoverable error occurs.
+	Run() error
+	// Gestures returns the channel that detected gestures are
+	// reported on.
+	Gestures() chan Gesture
+	// Updates returns the channel that in-progress gesture events
+	// (begin/update/end) are reported on.
+	Updates() chan GestureEvent
+}
+
+// NewBackend creates the Backend selected by cfg.Device.Backend.
+// The evdev backend is used when none is configured, to keep
+// existing configs working unchanged.
+func NewBackend(cfg *Config) (Backend, error) {
+
+	switch cfg.Device.Backend {
+
+	case "", "evdev":
+		return newEvdevBackend(cfg)
+	case "libinput":
+		return newLibinputBackend(cfg)
+	default:
+		return nil, fmt.Errorf("unknown backend: %s", cfg.Device.Backend)
 
-	if !finger.HasPositionY {
-		finger.FirstY = y
-		finger.HasPositionY = true
 	}
 
-	finger.LastY = y
-
-}
-
-// If the position of the finger has been set after the first
-// touch or since the last reset
-func (finger *Finger) hasPosition() bool {
-	return finger.HasPositionX && finger.HasPositionY
 }
 
-// Return the angle (0-360 degrees) of the last movement of the finger since
-// relative to the x-axis since the
-func (finger *Finger) getAngle() int {
+// Get name of the gesture type
+func getGestureTypeName(gesture gestureType) string {
+	switch gesture {
+	case SWIPE_UP:
+		return "Swipe Up"
+	case SWIPE_DOWN:
+		return "Swipe Down"
+	case SWIPE_LEFT:
+		return "Swipe Left"
+	case SWIPE_RIGHT:
+		return "Swipe Right"
+	case PINCH:
+		return "Pinch"
+	case SPREAD:
+		return "Spread"
+	case HOLD:
+		return "Hold"
+	case TAP:
+		return "Tap"
+	case ROTATE_CW:
+		return "Rotate Clockwise"
+	case ROTATE_CCW:
+		return "Rotate Counter-clockwise"
+	case PATH:
+		return "Path"
+	default:
+		return "UNKNOWN"
 
-	if !finger.hasPosition() {
-		return 0
 	}
+}
 
-	dx := finger.LastX - finger.FirstX
-	dy := -(finger.LastY - finger.FirstY)
-
-	angle := int(math.Atan2(float64(dy), float64(dx)) * 180.0 / math.Pi)
-
-	if angle < 0 {
-		return 360 + angle
+// directionName returns the config "direction" value for a swipe
+// gestureType ("up", "down", "left" or "right"), or "" for anything
+// else.
+func directionName(gest gestureType) string {
+	switch gest {
+	case SWIPE_UP:
+		return "up"
+	case SWIPE_DOWN:
+		return "down"
+	case SWIPE_LEFT:
+		return "left"
+	case SWIPE_RIGHT:
+		return "right"
+	default:
+		return ""
 	}
-	return angle
-
 }
 
-// Returns the direction of the movement of finger relative to the
-// first position since the first touch or reset.
-func (finger *Finger) getDirection() gestureType {
-
-	angle := finger.getAngle()
-
-	switch {
-
-	case angle >= 45 && angle <= 135:
+// gestureTypeFromName parses the gesture type names used by the control
+// socket's simulate-gesture command ("swipe-up", "swipe-down",
+// "swipe-left", "swipe-right", "pinch", "spread", "hold", "tap",
+// "rotate-cw", "rotate-ccw"), the inverse of directionName plus the
+// non-swipe gesture types. Returns UNKNOWN for anything else.
+func gestureTypeFromName(name string) gestureType {
+	switch name {
+	case "swipe-up":
 		return SWIPE_UP
-	case angle >= 135 && angle <= 225:
-		return SWIPE_LEFT
-	case angle >= 225 && angle <= 315:
+	case "swipe-down":
 		return SWIPE_DOWN
-	case angle >= 315 || angle <= 45:
+	case "swipe-left":
+		return SWIPE_LEFT
+	case "swipe-right":
 		return SWIPE_RIGHT
+	case "pinch":
+		return PINCH
+	case "spread":
+		return SPREAD
+	case "hold":
+		return HOLD
+	case "tap":
+		return TAP
+	case "rotate-cw":
+		return ROTATE_CW
+	case "rotate-ccw":
+		return ROTATE_CCW
 	default:
 		return UNKNOWN
-
 	}
-
 }
 
-// The distance the finger has moved relative to the
-// first postion of the finger since the first touch or reset
-func (finger *Finger) getDistance() int {
-
-	if !finger.hasPosition() {
-		return 0
-	}
-
-	return calculateDistance(finger.LastX, finger.LastY, finger.FirstX, finger.FirstY)
-}
-
-// Check if the finger has has a movement that is considered a swipeing motion.
-func (finger *Finger) hasSwiped(distanceThreshold int) bool {
+// Create a command from a string
+func createCommand(command string) *exec.Cmd {
 
-	if !finger.hasPosition() {
-		return false
-	}
+	args, err := shellwords.Parse(command)
 
-	if diff := time.Since(finger.ActivationTime); diff < (CHECK_DELAY * time.Millisecond) {
-		return false
+	if err != nil {
+		fmt.Println(err)
+		return nil
 	}
 
-	return finger.getDistance() > distanceThreshold
-
-}
-
-/*
-	EventHandler ========================
-*/
-type EventHandler struct {
-	// Five fingers
-	fingers [5]Finger
-	// The current finger that is modified by the events
-	currentSlot int
-	// The last reported gesture type
-	lastGesture gestureType
-	// The number of active fingers touching the pad
-	fingerCount int
-	// Used to keep track of the last check for a gesture
-	checkTimer time.Time
-	// Suspend any new gestures until the fingers are lifted
-	// from the pad. Used to prevent reporting of multiple gestures in
-	// one movement.
-	suspend bool
-	// Channel for detected gestures
-	Gestures chan Gesture
-
-	// The number of fingerCounts that have gestures
-	// defined in the config. There is no need to detect
-	// gestures if the no action is defined for that number
-	// of fingers tounching.
-	configuredFingers map[int]bool
-}
-
-func (handler *EventHandler) resetFingers() {
+	if len(args) > 1 {
+		return exec.Command(args[0], (args[1:])...)
 
-	for i := range handler.fingers {
-		handler.fingers[i].reset()
 	}
-	handler.checkTimer = time.Now()
+	return exec.Command(args[0])
 
 }
 
-func (handler *EventHandler) handleEvent(event *evdev.InputEvent) {
+func getActiveWindowClass(xutil *xgbutil.XUtil) (string, error) {
 
-	switch event.Type {
+	client, err := ewmh.ActiveWindowGet(xutil)
 
-	case evdev.EV_SYN:
-		handler.handleSynEvent(event)
-	case evdev.EV_ABS:
-		handler.handleAbsEvent(event)
+	if err != nil {
+		return "", err
 	}
 
-}
-
-func (handler *EventHandler) handleSynEvent(event *evdev.InputEvent) {
+	class, err := icccm.WmClassGet(xutil, client)
 
-	// Only check for gestures after a report event and not suspended
-	if event.Code == evdev.SYN_REPORT && !handler.suspend {
-		handler.detectGesture()
+	if err != nil {
+		return "", err
 	}
 
-}
+	return class.Class, nil
 
-func (handler *EventHandler) detectGesture() {
+}
 
-	// Time since last reset
-	timeDiff := time.Since(handler.checkTimer)
+// findBinding returns the most specific Binding configured for a
+// gesture, trying window- and edge-qualified bindings first and
+// falling back to progressively less specific ones:
+//
+//  1. this window, this edge
+//  2. this window, any edge
+//  3. every window, this edge
+//  4. every window, any edge
+//
+// where "this edge" is region, or "none" if the gesture didn't start
+// near an edge, and "every window" is the "" window class. direction
+// and path are only compared for binding types that use them (see
+// Binding).
+func findBinding(cfg *Config, bindingType BindingType, direction, path string, fingers int, window, region string, progress bool) *Binding {
 
-	// Do we have enought finger or enought time passed since the last reset
-	if _, ok := handler.configuredFingers[handler.fingerCount]; !ok || handler.fingerCount < 2 || timeDiff < (CHECK_DELAY*time.Millisecond) {
-		// fmt.Println(handler.fingerCount, timeDiff)
-		return
+	edge := region
+	if len(edge) == 0 {
+		edge = "none"
 	}
 
-	isSwipe := false
-	gesture := UNKNOWN
+	passes := [4]struct{ window, edge string }{
+		{window, edge},
+		{window, "*"},
+		{"", edge},
+		{"", "*"},
+	}
 
-	// We only register a swipe if all the fingers
-	// reports a swipe in the same direction
-	for i := range handler.fingers {
+	for _, pass := range passes {
+		for i := range cfg.Bindings {
 
-		fing := &handler.fingers[i]
+			binding := &cfg.Bindings[i]
 
-		if !fing.IsActive {
-			continue
-		}
-
-		if fing.hasSwiped(DIST_SWIPE) {
-			isSwipe = true
-			if i == 0 {
-				gesture = fing.getDirection()
-			} else if fing.getDirection() != gesture {
-				// Not all fingers moved in the same direction
-				// so its not a swipe
-				isSwipe = false
-				break
+			if binding.Type != bindingType || binding.Fingers != fingers || binding.Progress != progress {
+				continue
 			}
-		} else {
 
-			// Not all fingers has moved enough to register a swipe
-			// so we return
-
-			// If it has been more than some ms since the last reset and
-			// no gesture is detected we reset
-			if timeDiff > (220 * time.Millisecond) {
-				handler.resetFingers()
+			if len(direction) > 0 && binding.Direction != direction {
+				continue
 			}
-			return
-		}
-	}
-
-	if isSwipe {
-
-		if handler.lastGesture != gesture {
 
-			handler.emitGesture(Gesture{gesture, handler.fingerCount})
-			// Suspend gestures until fingers are lifted from the pad
-			handler.suspend = true
-		}
-
-	} else {
-
-		// It was not a straight swipe so check for other gestures
-		gesture := handler.calculateGesture()
-
-		if gesture != UNKNOWN {
+			if len(path) > 0 && binding.Path != path {
+				continue
+			}
 
-			if handler.lastGesture != gesture {
+			if binding.Window != pass.window {
+				continue
+			}
 
-				handler.emitGesture(Gesture{gesture, handler.fingerCount})
-				// Suspend gestures until fingers are lifted from the pad
-				handler.suspend = true
+			bindingEdge := binding.Edge
+			if len(bindingEdge) == 0 {
+				bindingEdge = "*"
+			}
 
+			if bindingEdge != pass.edge {
+				continue
 			}
 
+			return binding
+
 		}
 	}
 
-	// When we get here we have either reported a swipe and is suspended or it was an unknown movement
-	// so we reset the fingers to check for a new gesture if the last movement was not a gesture
-	handler.resetFingers()
+	return nil
 
 }
 
-func (handler *EventHandler) emitGesture(gesture Gesture) {
+// bindingTypeFor returns the BindingType and (for swipes) direction a
+// finished Gesture should be looked up with.
+func bindingTypeFor(gest *Gesture) (BindingType, string) {
 
-	handler.lastGesture = gesture.GestureType
-	handler.Gestures <- gesture
+	switch gest.GestureType {
+	case SWIPE_UP, SWIPE_DOWN, SWIPE_LEFT, SWIPE_RIGHT:
+		return BindingSwipe, directionName(gest.GestureType)
+	case PINCH:
+		return BindingPinch, ""
+	case SPREAD:
+		return BindingSpread, ""
+	case HOLD:
+		return BindingHold, ""
+	case TAP:
+		return BindingTap, ""
+	case ROTATE_CW:
+		return BindingRotateCW, ""
+	case ROTATE_CCW:
+		return BindingRotateCCW, ""
+	case PATH:
+		return BindingPath, ""
+	default:
+		return "", ""
+	}
 
 }
 
-func (handler *EventHandler) handleAbsEvent(event *evdev.InputEvent) {
-
-	switch event.Code {
+func getCommand(cfg *Config, gest *Gesture, window string) *exec.Cmd {
 
-	case evdev.ABS_MT_SLOT:
-		handler.currentSlot = int(event.Value)
-	case evdev.ABS_MT_TRACKING_ID:
-
-		prevCount := handler.fingerCount
-
-		if event.Value == -1 {
-			handler.fingerCount -= 1
-			handler.fingers[handler.currentSlot].deactivate()
-		} else {
-			handler.fingerCount += 1
-			handler.fingers[handler.currentSlot].activate()
-		}
-
-		handler.resetFingers()
+	bindingType, direction := bindingTypeFor(gest)
+	if bindingType == "" {
+		return nil
+	}
 
-		// If previously no fingers was toucing we get
-		// ready to handle a new gesture
-		if prevCount == 0 {
-			handler.lastGesture = UNKNOWN
-			handler.suspend = false
-		}
+	binding := findBinding(cfg, bindingType, direction, gest.Path, gest.FingerCount, window, gest.Region, false)
 
-	case evdev.ABS_MT_POSITION_X:
-		handler.fingers[handler.currentSlot].setPositionX(int(event.Value))
-	case evdev.ABS_MT_POSITION_Y:
-		handler.fingers[handler.currentSlot].setPositionY(int(event.Value))
+	if binding == nil {
+		return nil
 	}
 
-}
+	return createCommand(binding.Command)
 
-// Returns the gesture type from the last movement of the
-// fingers if it was not a stright swipe to one of the sides
-func (handler *EventHandler) calculateGesture() gestureType {
+}
 
-	var startPositions [][2]int
-	var endPositions [][2]int
+// How far the scale must have moved from 1.0 before a pinch/spread
+// in progress is considered to dominate over a directional swipe.
+const PROGRESS_SCALE_THRESHOLD = 0.05
 
-	for i := range handler.fingers {
+// directionFromDelta maps an accumulated (dx, dy) swipe movement to one
+// of the four swipe gesture types, the same way Finger.getDirection does
+// for the evdev backend. It is shared by both backends' progress events.
+func directionFromDelta(dx, dy float64) gestureType {
 
-		fing := &handler.fingers[i]
+	if dx == 0 && dy == 0 {
+		return UNKNOWN
+	}
 
-		if !fing.IsActive {
-			continue
-		}
-		if !fing.hasSwiped(DIST_OTHER) {
-			return UNKNOWN
+	if math.Abs(dy) >= math.Abs(dx) {
+		if dy < 0 {
+			return SWIPE_UP
 		}
-
-		startPositions = append(startPositions, [2]int{fing.FirstX, fing.FirstY})
-		endPositions = append(endPositions, [2]int{fing.LastX, fing.LastY})
-
+		return SWIPE_DOWN
 	}
 
-	// Calculate the circumference around the fingers in the start and end position
-	// to determine if the fingers was pinched or spread.
-	start := calculateCircumference(startPositions)
-	end := calculateCircumference(endPositions)
-
-	if start > end {
-		return PINCH
-	} else {
-		return SPREAD
+	if dx < 0 {
+		return SWIPE_LEFT
 	}
+	return SWIPE_RIGHT
 
 }
 
-// Listen for event from the input device
-func (handler *EventHandler) run(dev *evdev.InputDevice) {
-
-	var events []evdev.InputEvent
-
-	for {
-		events, _ = dev.Read()
-		for i := range events {
-
-			handler.handleEvent(&events[i])
+func getProgressCommand(cfg *Config, event *GestureEvent, window string) *exec.Cmd {
 
+	if event.Kind == GestureBegin {
+		// Dx/Dy/Scale are still at their zero values at the very start
+		// of a gesture, so neither branch below would ever match --
+		// look up a progress binding by fingers/window/region alone,
+		// independent of which swipe direction or pinch/spread it
+		// turns out to be, so e.g. a workspace-preview command can
+		// initialize itself right as the gesture begins.
+		for _, bindingType := range [...]BindingType{BindingSwipe, BindingPinch, BindingSpread} {
+			if binding := findBinding(cfg, bindingType, "", "", event.FingerCount, window, event.Region, true); binding != nil {
+				return createCommand(binding.Command)
+			}
 		}
-
+		return nil
 	}
 
-}
+	if math.Abs(event.Scale-1.0) >= PROGRESS_SCALE_THRESHOLD {
 
-/*
-	Helper functions =====================
-*/
+		bindingType := BindingPinch
+		if event.Scale >= 1.0 {
+			bindingType = BindingSpread
+		}
 
-// Calculate the circumference around the points
-func calculateCircumference(points [][2]int) int {
+		if binding := findBinding(cfg, bindingType, "", "", event.FingerCount, window, event.Region, true); binding != nil {
+			return createCommand(binding.Command)
+		}
 
-	if len(points) == 2 {
-		return calculateDistance(points[0][0], points[0][1], points[1][0], points[1][1])
 	}
 
-	total := 0
-	p0 := points[0]
-
-	for _, p := range points {
-		total += calculateDistance(p0[0], p0[1], p[0], p[1])
-		p0 = p
+	direction := directionName(directionFromDelta(event.Dx, event.Dy))
+	if len(direction) == 0 {
+		return nil
 	}
 
-	total += calculateDistance(points[0][0], points[0][1], p0[0], p0[1])
+	if binding := findBinding(cfg, BindingSwipe, direction, "", event.FingerCount, window, event.Region, true); binding != nil {
+		return createCommand(binding.Command)
+	}
 
-	return total
+	return nil
 
 }
 
-// Calculate distance between two points
-func calculateDistance(x0, y0, x, y int) int {
+// Set the TPSWIPE_* environment variables a progress command can
+// read to know how far along the gesture is.
+func setProgressEnv(cmd *exec.Cmd, event *GestureEvent) {
 
-	return int(math.Sqrt(math.Pow(float64(x-x0), 2) +
-		math.Pow(float64(y-y0), 2)))
-
-}
+	phase := "update"
+	if event.Kind == GestureBegin {
+		phase = "begin"
+	} else if event.Kind == GestureEnd {
+		phase = "end"
+	}
 
-// Get name of the gesture type
-func getGestureTypeName(gesture gestureType) string {
-	switch gesture {
-	case SWIPE_UP:
-		return "Swipe Up"
-	case SWIPE_DOWN:
-		return "Swipe Down"
-	case SWIPE_LEFT:
-		return "Swipe Left"
-	case SWIPE_RIGHT:
-		return "Swipe Right"
-	case PINCH:
-		return "Pinch"
-	case SPREAD:
-		return "Spread"
-	default:
-		return "UNKNOWN"
+	cmd.Env = append(os.Environ(),
+		fmt.Sprintf("TPSWIPE_PHASE=%s", phase),
+		fmt.Sprintf("TPSWIPE_DX=%f", event.Dx),
+		fmt.Sprintf("TPSWIPE_DY=%f", event.Dy),
+		fmt.Sprintf("TPSWIPE_SCALE=%f", event.Scale),
+		fmt.Sprintf("TPSWIPE_ANGLE=%f", event.Angle),
+	)
 
-	}
 }
 
-// Create a command from a string
-func createCommand(command string) *exec.Cmd {
-
-	args, err := shellwords.Parse(command)
+// Do something when a gesture arrives
+func handleGesture(gest *Gesture, xutil *xgbutil.XUtil, cfg *Config) {
 
-	if err != nil {
-		fmt.Println(err)
-		return nil
-	}
+	className, _ := getActiveWindowClass(xutil)
 
-	if len(args) > 1 {
-		return exec.Command(args[0], (args[1:])...)
+	cmd := getCommand(cfg, gest, className)
 
+	if cmd != nil {
+		if err := cmd.Run(); err != nil {
+			fmt.Println("Failed to run command:", err)
+		}
 	}
-	return exec.Command(args[0])
 
 }
 
-func getActiveWindowClass(xutil *xgbutil.XUtil) (string, error) {
+// Run the progress action, if any, bound to an in-progress gesture
+func handleGestureUpdate(event *GestureEvent, xutil *xgbutil.XUtil, cfg *Config) {
 
-	client, err := ewmh.ActiveWindowGet(xutil)
+	className, _ := getActiveWindowClass(xutil)
 
-	if err != nil {
-		return "", err
+	cmd := getProgressCommand(cfg, event, className)
+
+	if cmd == nil {
+		return
 	}
 
-	class, err := icccm.WmClassGet(xutil, client)
+	setProgressEnv(cmd, event)
 
-	if err != nil {
-		return "", err
+	if err := cmd.Run(); err != nil {
+		fmt.Println("Failed to run progress command:", err)
 	}
 
-	return class.Class, nil
+}
 
+// progressRunner serializes progress-bound command execution: it runs
+// at most one command at a time, in a single goroutine, so
+// exec.Cmd.Run() completions can't reorder across goroutines and
+// visibly snap a zoom/scrub backwards. If a newer GestureEvent is
+// queued while a command is still running, it replaces whatever was
+// still waiting to run next, so the runner never falls behind a burst
+// of updates.
+type progressRunner struct {
+	pending chan GestureEvent
 }
 
-func getCommand(gest *Gesture, actions *ActionCollection) *exec.Cmd {
+// newProgressRunner starts the runner's worker goroutine. getConfig is
+// called for every queued event so a reload-config command taking
+// effect mid-gesture is picked up by the next one.
+func newProgressRunner(xutil *xgbutil.XUtil, getConfig func() *Config) *progressRunner {
 
-	var cmd *exec.Cmd
+	runner := &progressRunner{pending: make(chan GestureEvent, 1)}
 
-	switch gest.GestureType {
-	case SWIPE_UP:
-		switch {
-		case gest.FingerCount == 2 && len(actions.Swipe2Up) > 0:
-			cmd = createCommand(actions.Swipe2Up)
-		case gest.FingerCount == 3 && len(actions.Swipe3Up) > 0:
-			cmd = createCommand(actions.Swipe3Up)
-		case gest.FingerCount == 4 && len(actions.Swipe4Up) > 0:
-			cmd = createCommand(actions.Swipe4Up)
-		case gest.FingerCount == 5 && len(actions.Swipe5Up) > 0:
-			cmd = createCommand(actions.Swipe5Up)
+	go func() {
+		for event := range runner.pending {
+			handleGestureUpdate(&event, xutil, getConfig())
 		}
-	case SWIPE_DOWN:
-		switch {
-		case gest.FingerCount == 2 && len(actions.Swipe2Down) > 0:
-			cmd = createCommand(actions.Swipe2Down)
-		case gest.FingerCount == 3 && len(actions.Swipe3Down) > 0:
-			cmd = createCommand(actions.Swipe3Down)
-		case gest.FingerCount == 4 && len(actions.Swipe4Down) > 0:
-			cmd = createCommand(actions.Swipe4Down)
-		case gest.FingerCount == 5 && len(actions.Swipe5Down) > 0:
-			cmd = createCommand(actions.Swipe5Down)
-		}
-	case SWIPE_LEFT:
-		switch {
-		case gest.FingerCount == 2 && len(actions.Swipe2Left) > 0:
-			cmd = createCommand(actions.Swipe2Left)
-		case gest.FingerCount == 3 && len(actions.Swipe3Left) > 0:
-			cmd = createCommand(actions.Swipe3Left)
-		case gest.FingerCount == 4 && len(actions.Swipe4Left) > 0:
-			cmd = createCommand(actions.Swipe4Left)
-		case gest.FingerCount == 5 && len(actions.Swipe5Left) > 0:
-			cmd = createCommand(actions.Swipe5Left)
-		}
-	case SWIPE_RIGHT:
-		switch {
-		case gest.FingerCount == 2 && len(actions.Swipe2Right) > 0:
-			cmd = createCommand(actions.Swipe2Right)
-		case gest.FingerCount == 3 && len(actions.Swipe3Right) > 0:
-			cmd = createCommand(actions.Swipe3Right)
-		case gest.FingerCount == 4 && len(actions.Swipe4Right) > 0:
-			cmd = createCommand(actions.Swipe4Right)
-		case gest.FingerCount == 5 && len(actions.Swipe5Right) > 0:
-			cmd = createCommand(actions.Swipe5Right)
-		}
-	case PINCH:
-		switch {
-		case gest.FingerCount == 2 && len(actions.Pinch2) > 0:
-			cmd = createCommand(actions.Pinch2)
-		case gest.FingerCount == 3 && len(actions.Pinch3) > 0:
-			cmd = createCommand(actions.Pinch3)
-		case gest.FingerCount == 4 && len(actions.Pinch4) > 0:
-			cmd = createCommand(actions.Pinch4)
-		case gest.FingerCount == 5 && len(actions.Pinch5) > 0:
-			cmd = createCommand(actions.Pinch5)
-		}
-	case SPREAD:
-		switch {
-		case gest.FingerCount == 2 && len(actions.Spread2) > 0:
-			cmd = createCommand(actions.Spread2)
-		case gest.FingerCount == 3 && len(actions.Spread3) > 0:
-			cmd = createCommand(actions.Spread3)
-		case gest.FingerCount == 4 && len(actions.Spread4) > 0:
-			cmd = createCommand(actions.Spread4)
-		case gest.FingerCount == 5 && len(actions.Spread5) > 0:
-			cmd = createCommand(actions.Spread5)
-		}
-
-	}
+	}()
 
-	return cmd
+	return runner
 
 }
 
-// Do something when a gesture arrives
-func handleGesture(gest *Gesture, xutil *xgbutil.XUtil, cfg *Config) {
-
-	var cmd *exec.Cmd
-
-	className, _ := getActiveWindowClass(xutil)
-
-	actions := cfg.Actions[className]
+// queue replaces any not-yet-started event with event, so the worker
+// goroutine is always about to run the most recent update.
+func (runner *progressRunner) queue(event GestureEvent) {
 
-	if actions == nil {
-		// If its no specific window actions try the global actions
-		actions = cfg.Actions[""]
-	} else {
-		cmd = getCommand(gest, actions)
-		// If there is no action is specified for this gesture type try the global actions
-		if cmd == nil {
-			actions = cfg.Actions[""]
-		}
-	}
-
-	// If no actions is defined just return
-	if actions == nil {
-		return
-	}
-
-	cmd = getCommand(gest, actions)
-
-	if cmd != nil {
-		err := cmd.Run()
-		if err != nil {
-			fmt.Println("Failed to run command:", err)
+	select {
+	case runner.pending <- event:
+	default:
+		select {
+		case <-runner.pending:
+		default:
 		}
+		runner.pending <- event
 	}
 
 }
 
-// Get the finger counts that have actions defined in the
-// config.
+// Get the finger counts that have bindings or custom gestures defined
+// in the config. There is no need to detect gestures for a finger
+// count nothing is bound to.
 func getConfiguredFingers(cfg *Config) map[int]bool {
 
 	fingers := make(map[int]bool)
 
-	for _, actions := range cfg.Actions {
-
-		val := reflect.ValueOf(*actions)
-
-		for i := 0; i < val.NumField(); i++ {
-
-			field := val.Field(i)
-
-			if len(field.String()) == 0 {
-				continue
-			}
-
-			for j := 1; j <= 5; j++ {
-
-				if strings.Index(val.Type().Field(i).Name, strconv.Itoa(j)) != -1 {
-					fingers[j] = true
-				}
-
-			}
-
-		}
+	for _, binding := range cfg.Bindings {
+		fingers[binding.Fingers] = true
+	}
 
+	for _, gesture := range cfg.Gestures {
+		fingers[gesture.Fingers] = true
 	}
 
 	return fingers
@@ -664,7 +551,7 @@ func main() {
 		os.Exit(1)
 	}
 	configFile := flag.String("config",
-		fmt.Sprintf("%s/.config/tpswipe.conf", usr.HomeDir),
+		fmt.Sprintf("%s/.config/tpswipe.toml", usr.HomeDir),
 		"Config file")
 
 	testTouches := flag.Bool("test", false, "Test gestures")
@@ -673,36 +560,60 @@ func main() {
 
 	var cfg Config
 
-	err = gcfg.ReadFileInto(&cfg, *configFile)
+	_, err = toml.DecodeFile(*configFile, &cfg)
 
 	if err != nil {
 		fmt.Println("Config error:", err)
 		os.Exit(1)
 	}
 
-	if len(cfg.Device.Path) == 0 {
-		fmt.Println("No input device path in config")
-		os.Exit(1)
-	}
-
-	dev, err := evdev.Open(cfg.Device.Path)
+	backend, err := NewBackend(&cfg)
 
 	if err != nil {
-		fmt.Println("Failed to open divice:", err)
+		fmt.Println("Failed to set up backend:", err)
 		os.Exit(1)
 	}
 
-	configuredFingers := getConfiguredFingers(&cfg)
+	// Listen for events
+	go func() {
+		if err := backend.Run(); err != nil {
+			fmt.Println("Backend stopped:", err)
+			os.Exit(1)
+		}
+	}()
 
-	handler := EventHandler{Gestures: make(chan Gesture), configuredFingers: configuredFingers}
+	var control *ControlServer
+	if len(cfg.Control.Socket) > 0 {
 
-	// Listen for events
-	go handler.run(dev)
+		control, err = NewControlServer(cfg.Control.Socket, *configFile, &cfg)
+		if err != nil {
+			fmt.Println("Failed to set up control socket:", err)
+			os.Exit(1)
+		}
+		defer control.Close()
+
+		go func() {
+			if err := control.Run(); err != nil {
+				fmt.Println("Control socket stopped:", err)
+			}
+		}()
+
+	}
 
 	if *testTouches {
 		fmt.Println("Try to do some gestures on the trackpad")
 		for {
-			fmt.Println("Detected:", <-handler.Gestures)
+			select {
+			case gest := <-backend.Gestures():
+				fmt.Println("Detected:", gest)
+				go control.broadcastGesture(gest)
+			case event := <-backend.Updates():
+				fmt.Printf("Progress: dx=%.0f dy=%.0f scale=%.2f angle=%.1f\n",
+					event.Dx, event.Dy, event.Scale, event.Angle)
+				go control.broadcastUpdate(event)
+			case gest := <-control.simulated():
+				fmt.Println("Simulated:", gest)
+			}
 		}
 
 	} else {
@@ -714,51 +625,151 @@ func main() {
 			os.Exit(1)
 		}
 
+		progress := newProgressRunner(xutil, func() *Config { return control.currentConfig(&cfg) })
+
+		go func() {
+			for event := range backend.Updates() {
+				event := event
+				go control.broadcastUpdate(event)
+				if !control.isPaused() {
+					progress.queue(event)
+				}
+			}
+		}()
+
 		for {
 
-			gest := <-handler.Gestures
-			go handleGesture(&gest, xutil, &cfg)
+			select {
+			case gest := <-backend.Gestures():
+				go control.broadcastGesture(gest)
+				if !control.isPaused() {
+					go handleGesture(&gest, xutil, control.currentConfig(&cfg))
+				}
+			case gest := <-control.simulated():
+				if !control.isPaused() {
+					go handleGesture(&gest, xutil, control.currentConfig(&cfg))
+				}
+			}
 
 		}
 	}
 
 }
 
-type ActionCollection struct {
-	Swipe2Left string
-	Swipe3Left string
-	Swipe4Left string
-	Swipe5Left string
-
-	Swipe2Right string
-	Swipe3Right string
-	Swipe4Right string
-	Swipe5Right string
-
-	Swipe2Up string
-	Swipe3Up string
-	Swipe4Up string
-	Swipe5Up string
-
-	Swipe2Down string
-	Swipe3Down string
-	Swipe4Down string
-	Swipe5Down string
-
-	Pinch2 string
-	Pinch3 string
-	Pinch4 string
-	Pinch5 string
-
-	Spread2 string
-	Spread3 string
-	Spread4 string
-	Spread5 string
+// BindingType identifies which kind of gesture a Binding matches.
+type BindingType string
+
+const (
+	BindingSwipe  BindingType = "swipe"
+	BindingPinch  BindingType = "pinch"
+	BindingSpread BindingType = "spread"
+	BindingHold   BindingType = "hold"
+	// BindingTap only ever fires under Config.Device.Backend "evdev"
+	// -- libinput has no native tap gesture event, so the libinput
+	// backend never emits TAP.
+	BindingTap       BindingType = "tap"
+	BindingRotateCW  BindingType = "rotate-cw"
+	BindingRotateCCW BindingType = "rotate-ccw"
+	BindingPath      BindingType = "path"
+)
+
+// Binding binds a command to a gesture, e.g.:
+//
+//	[[binding]]
+//	fingers = 3
+//	type = "swipe"
+//	direction = "up"
+//	window = "firefox"
+//	edge = "*"
+//	command = "wmctrl -a firefox"
+type Binding struct {
+	Fingers int
+	Type    BindingType
+	// "up", "down", "left" or "right". Only used when Type is
+	// "swipe".
+	Direction string
+	// The name of a Gestures entry to match. Only used when Type is
+	// "path".
+	Path string
+	// The window class this binding applies to, or "" (the default)
+	// to apply to every window that has no more specific binding.
+	Window string
+	// The region of the trackpad the gesture must have started in:
+	// "*" (the default) for any region, "none" for away from every
+	// edge, or one of L/R/T/B/TL/TR/BL/BR. See classifyRegion.
+	Edge string
+	// If true, Command is run repeatedly while the gesture is in
+	// progress (see GestureEvent) instead of once it has finished.
+	// The running command is passed the progress as the
+	// TPSWIPE_PHASE, TPSWIPE_DX, TPSWIPE_DY, TPSWIPE_SCALE and
+	// TPSWIPE_ANGLE environment variables. Swipe, pinch and spread
+	// are the only types that currently report progress. At the very
+	// start of a gesture (TPSWIPE_PHASE=begin) its direction/scale
+	// isn't known yet, so getProgressCommand matches a swipe Binding
+	// by fingers/window/edge alone regardless of Direction -- if
+	// opposite directions (e.g. up and down) both have a Progress
+	// binding for the same fingers/window/edge, whichever is first in
+	// the config is the one that runs for the begin phase.
+	Progress bool
+	Command  string
+}
+
+// CustomGesture describes a compound, lisgd-style path gesture as an
+// ordered sequence of swipe directions, e.g. Segments = ["down",
+// "left", "up", "right"] for a DLUR path. Matched against the
+// direction changes recorded during a touch on finger lift, see
+// EventHandler.checkPath. A matching touch is reported as a PATH
+// Gesture with Gesture.Path set to Name, which a
+// `[[binding]] type = "path" path = "<Name>"` entry can bind a
+// command to.
+type CustomGesture struct {
+	Name     string
+	Fingers  int
+	Segments []string
 }
 
 type Config struct {
 	Device struct {
+		// Path to the evdev device node, e.g. /dev/input/event5.
+		// Only used by the evdev backend.
 		Path string
+		// Backend selects which Backend implementation to use:
+		// "evdev" (the default) or "libinput".
+		Backend string
+		// Seat passed to libinput_udev_assign_seat. Only used by
+		// the libinput backend, defaults to "seat0".
+		Seat string
+		// The fraction (0..1) of the trackpad's width/height, from
+		// each edge, that counts as that edge's region for
+		// edge-qualified bindings. Defaults to 0.1 (10%). Only used
+		// by the evdev backend.
+		EdgeMargin float64
+		// The distance (in the device's own units) a finger must
+		// move to register a swipe, and any other gesture
+		// (pinch/spread/rotate/path segment), respectively. Zero
+		// uses the built-in default (see DIST_SWIPE, DIST_OTHER).
+		// Only used by the evdev backend.
+		SwipeDistance int
+		OtherDistance int
+		// How often, in milliseconds, to check the fingers for a
+		// gesture. Zero uses the built-in default (see CHECK_DELAY).
+		// Only used by the evdev backend.
+		CheckDelay int
+		// How long, in milliseconds, an unrecognized movement is
+		// given before the fingers are reset to check for a new
+		// gesture. Zero uses the built-in default (see
+		// RESET_TIMEOUT). Only used by the evdev backend.
+		ResetTimeout int
+	}
+	Bindings []Binding       `toml:"binding"`
+	Gestures []CustomGesture `toml:"gesture"`
+	Control  struct {
+		// Path to a Unix socket that detected gestures and lifecycle
+		// events (paused/resumed/reloaded) are published on as JSON
+		// lines, and that accepts reload-config/pause/resume/
+		// simulate-gesture/list-bindings commands, one JSON object
+		// per line. Empty (the default) disables the control socket.
+		// See ControlServer.
+		Socket string
 	}
-	Actions map[string]*ActionCollection
 }